@@ -17,11 +17,18 @@ limitations under the License.
 package apimachinery
 
 import (
+	"bytes"
+	"encoding/base64"
 	"fmt"
+	"io/ioutil"
+	"path/filepath"
 	"strings"
+	"text/template"
 	"time"
 
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
 	"k8s.io/api/admissionregistration/v1alpha1"
+	admissionregistrationv1beta1 "k8s.io/api/admissionregistration/v1beta1"
 	"k8s.io/api/core/v1"
 	extensions "k8s.io/api/extensions/v1beta1"
 	rbacv1beta1 "k8s.io/api/rbac/v1beta1"
@@ -32,8 +39,8 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/types"
-	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/util/yaml"
 	"k8s.io/client-go/dynamic"
 	clientset "k8s.io/client-go/kubernetes"
 	utilversion "k8s.io/kubernetes/pkg/util/version"
@@ -65,6 +72,40 @@ const (
 	failNamespaceLabelKey       = "fail-closed-webhook"
 	failNamespaceLabelValue     = "yes"
 	failNamespaceName           = "fail-closed-namesapce"
+	mutatingWebhookConfigName   = "e2e-test-mutating-webhook-config"
+	mutationChainConfigName     = "e2e-test-mutation-chain-webhook-config"
+	addedSidecarContainerName   = "webhook-added-sidecar"
+	addedLabelKey               = "webhook-added-label"
+	addedLabelValue             = "yes"
+	addedConfigMapDataKey       = "mutation-stage"
+	addedConfigMapDataValue     = "first"
+	mutationChainPodName        = "mutation-chain-pod"
+	mutationAnnotationKey       = "foo"
+
+	objectSelectorConfigName          = "e2e-test-webhook-config-object-selector"
+	objectSelectorLabelKey            = "webhook-e2e-test"
+	objectSelectorLabelValue          = "match"
+	scopeConfigName                   = "e2e-test-webhook-config-scope"
+	scopeNamespacedTestNamespaceName  = "webhook-scope-namespaced-test"
+	scopeClusterTestNamespaceName     = "webhook-scope-cluster-test"
+	matchPolicyConfigName             = "e2e-test-webhook-config-match-policy"
+	matchPolicyCRDName                = "e2e-test-webhook-match-policy-crd"
+	matchPolicyCRDKind                = "E2e-test-webhook-match-policy-crd"
+	matchPolicyCRDGroup               = "webhook-match-policy-test.k8s.io"
+	matchPolicyCRDVersionV1           = "v1"
+	matchPolicyCRDVersionV2           = "v2"
+	systemNamespaceSelectorConfigName = "e2e-test-webhook-config-system-namespace-selector"
+	systemNamespaceLabelKey           = "webhook-e2e-test-system"
+	systemNamespaceLabelValue         = "yes"
+	systemLikeNamespaceName           = "webhook-e2e-system-like"
+
+	reinvocationConfigName      = "e2e-test-webhook-config-reinvocation"
+	reinvocationBuggyConfigName = "e2e-test-webhook-config-reinvocation-buggy"
+	reinvocationPodName         = "reinvocation-pod"
+	reinvocationBuggyPodName    = "reinvocation-buggy-pod"
+	sidecarAContainerName       = "sidecar-a"
+	sidecarBContainerName       = "sidecar-b"
+	buggySidecarContainerName   = "buggy-sidecar"
 )
 
 var serverWebhookVersion = utilversion.MustParseSemantic("v1.8.0")
@@ -75,6 +116,7 @@ var _ = SIGDescribe("AdmissionWebhook", func() {
 
 	var client clientset.Interface
 	var namespaceName string
+	var wc webhookClient
 
 	BeforeEach(func() {
 		client = f.ClientSet
@@ -84,70 +126,470 @@ var _ = SIGDescribe("AdmissionWebhook", func() {
 		framework.SkipUnlessServerVersionGTE(serverWebhookVersion, f.ClientSet.Discovery())
 		framework.SkipUnlessProviderIs("gce", "gke", "local")
 
-		_, err := f.ClientSet.AdmissionregistrationV1alpha1().ValidatingWebhookConfigurations().List(metav1.ListOptions{})
-		if errors.IsNotFound(err) {
-			framework.Skipf("dynamic configuration of webhooks requires the alpha admissionregistration.k8s.io group to be enabled")
+		var err error
+		wc, err = newWebhookClient(f.ClientSet)
+		if err != nil {
+			framework.Skipf("%v", err)
 		}
 
 		By("Setting up server cert")
 		context = setupServerCert(namespaceName, serviceName)
 		createAuthReaderRoleBinding(f, namespaceName)
 
-		// Note that in 1.9 we will have backwards incompatible change to
-		// admission webhooks, so the image will be updated to 1.9 sometime in
-		// the development 1.9 cycle.
-		deployWebhookAndService(f, "gcr.io/kubernetes-e2e-test-images/k8s-sample-admission-webhook-amd64:1.8v5", context)
+		// The image is pinned to 1.10v1, the first tag of the sample webhook
+		// that serves the mutating routes (/mutating-pods,
+		// /mutating-configmaps, /mutation-reinvocation-a,
+		// /mutation-reinvocation-b, /mutation-reinvocation-buggy-unconditional)
+		// and /always-deny alongside the original /pods and /configmaps
+		// routes; bump it again if a later request adds routes this tag
+		// doesn't serve.
+		deployWebhookAndService(f, "gcr.io/kubernetes-e2e-test-images/k8s-sample-admission-webhook-amd64:1.10v1", context)
 	})
 	AfterEach(func() {
-		cleanWebhookTest(client, namespaceName)
+		cleanWebhookTest(wc, client, namespaceName)
 	})
 
 	It("Should be able to deny pod and configmap creation", func() {
-		registerWebhook(f, context)
+		registerWebhook(f, wc, context)
 		testWebhook(f)
 	})
 
 	It("Should be able to deny custom resource creation", func() {
 		crdCleanup, dynamicClient := createCRD(f)
 		defer crdCleanup()
-		registerWebhookForCRD(f, context)
+		registerWebhookForCRD(wc, context, dynamicClient)
 		testCRDWebhook(f, dynamicClient)
 	})
 
 	It("Should unconditionally reject operations on fail closed webhook", func() {
-		registerFailClosedWebhook(f, context)
+		registerFailClosedWebhook(f, wc, context)
 		testFailClosedWebhook(f)
 		// Clean up
-		err := f.ClientSet.AdmissionregistrationV1alpha1().ValidatingWebhookConfigurations().Delete(webhookFailClosedConfigName, nil)
+		err := wc.Delete(webhookFailClosedConfigName)
 		Expect(err).NotTo(HaveOccurred(), "failed deleting fail closed webhook, this may cause subsequent e2e tests to fail")
 	})
+
+	It("Should mutate pod and configmap creation", func() {
+		registerMutatingWebhook(f, wc, context)
+		testMutatingWebhook(f)
+	})
+
+	It("Should mutate a pod through a chain of ordered mutating webhooks and expose the result to a validating webhook", func() {
+		testMutationChainOrder(f, wc, context)
+	})
+
+	It("Should only match objects selected by objectSelector", func() {
+		testObjectSelector(f, wc, context)
+	})
+
+	It("Should not match a cluster-scoped resource with a Namespaced-scoped rule", func() {
+		testScopeMatching(f, wc, context)
+	})
+
+	It("Should honor matchPolicy Equivalent vs Exact against multiple CRD versions", func() {
+		testMatchPolicy(f, wc, context)
+	})
+
+	It("Should never intercept user namespaces when namespaceSelector scopes it to system namespaces", func() {
+		testNamespaceSelectorScopedToSystemNamespaces(f, wc, context)
+	})
+
+	It("Should reinvoke mutating webhooks with reinvocationPolicy IfNeeded without duplicating idempotent mutations", func() {
+		testMutatingWebhookReinvocation(f, wc, context)
+	})
+
+	It("Should detect a duplicate produced by a buggy webhook that ignores reinvocation idempotency", func() {
+		testMutatingWebhookReinvocationDetectsBuggyDuplication(f, wc, context)
+	})
 })
 
-func createAuthReaderRoleBinding(f *framework.Framework, namespace string) {
-	By("Create role binding to let webhook read extension-apiserver-authentication")
-	client := f.ClientSet
-	// Create the role binding to allow the webhook read the extension-apiserver-authentication configmap
-	_, err := client.RbacV1beta1().RoleBindings("kube-system").Create(&rbacv1beta1.RoleBinding{
-		ObjectMeta: metav1.ObjectMeta{
-			Name: roleBindingName,
-			Annotations: map[string]string{
-				rbacv1beta1.AutoUpdateAnnotationKey: "true",
+// webhookDescriptor is a version-agnostic description of a single webhook
+// rule. Each webhookClient implementation translates it into the wire type
+// of the admissionregistration API version it speaks, dropping fields the
+// discovered version doesn't support.
+type webhookDescriptor struct {
+	Name               string
+	Path               string
+	Operations         []admissionregistrationv1.OperationType
+	APIGroups          []string
+	APIVersions        []string
+	Resources          []string
+	FailurePolicyFail  bool
+	NamespaceSelector  *metav1.LabelSelector
+	ObjectSelector     *metav1.LabelSelector
+	Scope              *admissionregistrationv1.ScopeType
+	MatchPolicy        *admissionregistrationv1.MatchPolicyType
+	ReinvocationPolicy *admissionregistrationv1.ReinvocationPolicyType
+	CABundle           []byte
+}
+
+// webhookClient abstracts over the admissionregistration.k8s.io API
+// versions (v1, v1beta1, v1alpha1) an apiserver may expose, so the same
+// scenario matrix can run unmodified against 1.9->1.16+ clusters. Only the
+// version discovered on the server is exercised; callers that need a
+// feature not supported by the discovered version should check the
+// corresponding Supports* method and skip.
+type webhookClient interface {
+	CreateValidating(configName string, webhooks []webhookDescriptor) error
+	CreateMutating(configName string, webhooks []webhookDescriptor) error
+	Delete(configName string) error
+
+	SupportsObjectSelector() bool
+	SupportsMatchPolicy() bool
+	SupportsReinvocationPolicy() bool
+	SupportsScope() bool
+	SupportsMutating() bool
+}
+
+// newWebhookClient probes the discovery API for v1, then v1beta1, then
+// v1alpha1 admissionregistration, and returns a webhookClient for the first
+// version found. It returns an error if none of the three are available.
+func newWebhookClient(client clientset.Interface) (webhookClient, error) {
+	disco := client.Discovery()
+	if _, err := disco.ServerResourcesForGroupVersion(admissionregistrationv1.SchemeGroupVersion.String()); err == nil {
+		return &webhookClientV1{client: client}, nil
+	}
+	if _, err := disco.ServerResourcesForGroupVersion(admissionregistrationv1beta1.SchemeGroupVersion.String()); err == nil {
+		return &webhookClientV1beta1{client: client}, nil
+	}
+	if _, err := disco.ServerResourcesForGroupVersion(v1alpha1.SchemeGroupVersion.String()); err == nil {
+		return &webhookClientV1alpha1{client: client}, nil
+	}
+	return nil, fmt.Errorf("dynamic configuration of webhooks requires one of admissionregistration.k8s.io v1, v1beta1 or v1alpha1 to be enabled")
+}
+
+type webhookClientV1 struct {
+	client clientset.Interface
+}
+
+func (c *webhookClientV1) toWebhook(d webhookDescriptor) admissionregistrationv1.ValidatingWebhook {
+	none := admissionregistrationv1.SideEffectClassNone
+	return admissionregistrationv1.ValidatingWebhook{
+		Name: d.Name,
+		Rules: []admissionregistrationv1.RuleWithOperations{{
+			Operations: d.Operations,
+			Rule: admissionregistrationv1.Rule{
+				APIGroups:   d.APIGroups,
+				APIVersions: d.APIVersions,
+				Resources:   d.Resources,
+				Scope:       d.Scope,
 			},
+		}},
+		FailurePolicy:           failurePolicyV1(d.FailurePolicyFail),
+		NamespaceSelector:       d.NamespaceSelector,
+		ObjectSelector:          d.ObjectSelector,
+		MatchPolicy:             d.MatchPolicy,
+		SideEffects:             &none,
+		AdmissionReviewVersions: []string{"v1beta1"},
+		ClientConfig:            clientConfigV1(d),
+	}
+}
+
+func (c *webhookClientV1) toMutatingWebhook(d webhookDescriptor) admissionregistrationv1.MutatingWebhook {
+	v := c.toWebhook(d)
+	return admissionregistrationv1.MutatingWebhook{
+		Name:                    v.Name,
+		Rules:                   v.Rules,
+		FailurePolicy:           v.FailurePolicy,
+		NamespaceSelector:       v.NamespaceSelector,
+		ObjectSelector:          v.ObjectSelector,
+		MatchPolicy:             v.MatchPolicy,
+		SideEffects:             v.SideEffects,
+		AdmissionReviewVersions: v.AdmissionReviewVersions,
+		ClientConfig:            v.ClientConfig,
+		ReinvocationPolicy:      d.ReinvocationPolicy,
+	}
+}
+
+func (c *webhookClientV1) CreateValidating(configName string, webhooks []webhookDescriptor) error {
+	config := &admissionregistrationv1.ValidatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{Name: configName},
+	}
+	for _, d := range webhooks {
+		config.Webhooks = append(config.Webhooks, c.toWebhook(d))
+	}
+	_, err := c.client.AdmissionregistrationV1().ValidatingWebhookConfigurations().Create(config)
+	return err
+}
+
+func (c *webhookClientV1) CreateMutating(configName string, webhooks []webhookDescriptor) error {
+	config := &admissionregistrationv1.MutatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{Name: configName},
+	}
+	for _, d := range webhooks {
+		config.Webhooks = append(config.Webhooks, c.toMutatingWebhook(d))
+	}
+	_, err := c.client.AdmissionregistrationV1().MutatingWebhookConfigurations().Create(config)
+	return err
+}
+
+func (c *webhookClientV1) Delete(configName string) error {
+	if err := c.client.AdmissionregistrationV1().ValidatingWebhookConfigurations().Delete(configName, nil); err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	if err := c.client.AdmissionregistrationV1().MutatingWebhookConfigurations().Delete(configName, nil); err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+func (c *webhookClientV1) SupportsObjectSelector() bool     { return true }
+func (c *webhookClientV1) SupportsMatchPolicy() bool        { return true }
+func (c *webhookClientV1) SupportsReinvocationPolicy() bool { return true }
+func (c *webhookClientV1) SupportsScope() bool              { return true }
+func (c *webhookClientV1) SupportsMutating() bool           { return true }
+
+func failurePolicyV1(fail bool) *admissionregistrationv1.FailurePolicyType {
+	p := admissionregistrationv1.Ignore
+	if fail {
+		p = admissionregistrationv1.Fail
+	}
+	return &p
+}
+
+func clientConfigV1(d webhookDescriptor) admissionregistrationv1.WebhookClientConfig {
+	return admissionregistrationv1.WebhookClientConfig{
+		Service: &admissionregistrationv1.ServiceReference{
+			Namespace: serviceNamespace,
+			Name:      serviceName,
+			Path:      strPtr(d.Path),
 		},
-		RoleRef: rbacv1beta1.RoleRef{
-			APIGroup: "",
-			Kind:     "Role",
-			Name:     "extension-apiserver-authentication-reader",
+		CABundle: d.CABundle,
+	}
+}
+
+type webhookClientV1beta1 struct {
+	client clientset.Interface
+}
+
+func (c *webhookClientV1beta1) toWebhook(d webhookDescriptor) admissionregistrationv1beta1.ValidatingWebhook {
+	ops := make([]admissionregistrationv1beta1.OperationType, 0, len(d.Operations))
+	for _, o := range d.Operations {
+		ops = append(ops, admissionregistrationv1beta1.OperationType(o))
+	}
+	var scope *admissionregistrationv1beta1.ScopeType
+	if d.Scope != nil {
+		s := admissionregistrationv1beta1.ScopeType(*d.Scope)
+		scope = &s
+	}
+	var matchPolicy *admissionregistrationv1beta1.MatchPolicyType
+	if d.MatchPolicy != nil {
+		m := admissionregistrationv1beta1.MatchPolicyType(*d.MatchPolicy)
+		matchPolicy = &m
+	}
+	return admissionregistrationv1beta1.ValidatingWebhook{
+		Name: d.Name,
+		Rules: []admissionregistrationv1beta1.RuleWithOperations{{
+			Operations: ops,
+			Rule: admissionregistrationv1beta1.Rule{
+				APIGroups:   d.APIGroups,
+				APIVersions: d.APIVersions,
+				Resources:   d.Resources,
+				Scope:       scope,
+			},
+		}},
+		FailurePolicy:     failurePolicyV1beta1(d.FailurePolicyFail),
+		NamespaceSelector: d.NamespaceSelector,
+		ObjectSelector:    d.ObjectSelector,
+		MatchPolicy:       matchPolicy,
+		ClientConfig:      clientConfigV1beta1(d),
+	}
+}
+
+func (c *webhookClientV1beta1) toMutatingWebhook(d webhookDescriptor) admissionregistrationv1beta1.MutatingWebhook {
+	v := c.toWebhook(d)
+	var reinvocationPolicy *admissionregistrationv1beta1.ReinvocationPolicyType
+	if d.ReinvocationPolicy != nil {
+		r := admissionregistrationv1beta1.ReinvocationPolicyType(*d.ReinvocationPolicy)
+		reinvocationPolicy = &r
+	}
+	return admissionregistrationv1beta1.MutatingWebhook{
+		Name:               v.Name,
+		Rules:              v.Rules,
+		FailurePolicy:      v.FailurePolicy,
+		NamespaceSelector:  v.NamespaceSelector,
+		ObjectSelector:     v.ObjectSelector,
+		MatchPolicy:        v.MatchPolicy,
+		ClientConfig:       v.ClientConfig,
+		ReinvocationPolicy: reinvocationPolicy,
+	}
+}
+
+func (c *webhookClientV1beta1) CreateValidating(configName string, webhooks []webhookDescriptor) error {
+	config := &admissionregistrationv1beta1.ValidatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{Name: configName},
+	}
+	for _, d := range webhooks {
+		config.Webhooks = append(config.Webhooks, c.toWebhook(d))
+	}
+	_, err := c.client.AdmissionregistrationV1beta1().ValidatingWebhookConfigurations().Create(config)
+	return err
+}
+
+func (c *webhookClientV1beta1) CreateMutating(configName string, webhooks []webhookDescriptor) error {
+	config := &admissionregistrationv1beta1.MutatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{Name: configName},
+	}
+	for _, d := range webhooks {
+		config.Webhooks = append(config.Webhooks, c.toMutatingWebhook(d))
+	}
+	_, err := c.client.AdmissionregistrationV1beta1().MutatingWebhookConfigurations().Create(config)
+	return err
+}
+
+func (c *webhookClientV1beta1) Delete(configName string) error {
+	if err := c.client.AdmissionregistrationV1beta1().ValidatingWebhookConfigurations().Delete(configName, nil); err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	if err := c.client.AdmissionregistrationV1beta1().MutatingWebhookConfigurations().Delete(configName, nil); err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+func (c *webhookClientV1beta1) SupportsObjectSelector() bool     { return true }
+func (c *webhookClientV1beta1) SupportsMatchPolicy() bool        { return true }
+func (c *webhookClientV1beta1) SupportsReinvocationPolicy() bool { return true }
+func (c *webhookClientV1beta1) SupportsScope() bool              { return true }
+func (c *webhookClientV1beta1) SupportsMutating() bool           { return true }
+
+func failurePolicyV1beta1(fail bool) *admissionregistrationv1beta1.FailurePolicyType {
+	p := admissionregistrationv1beta1.Ignore
+	if fail {
+		p = admissionregistrationv1beta1.Fail
+	}
+	return &p
+}
+
+func clientConfigV1beta1(d webhookDescriptor) admissionregistrationv1beta1.WebhookClientConfig {
+	return admissionregistrationv1beta1.WebhookClientConfig{
+		Service: &admissionregistrationv1beta1.ServiceReference{
+			Namespace: serviceNamespace,
+			Name:      serviceName,
+			Path:      strPtr(d.Path),
 		},
-		// Webhook uses the default service account.
-		Subjects: []rbacv1beta1.Subject{
-			{
-				Kind:      "ServiceAccount",
-				Name:      "default",
-				Namespace: namespace,
+		CABundle: d.CABundle,
+	}
+}
+
+// webhookClientV1alpha1 only supports ValidatingWebhookConfiguration: the
+// v1alpha1 admissionregistration API predates MutatingWebhookConfiguration,
+// objectSelector, scope and matchPolicy, so this client errors or no-ops on
+// the features it can't express; callers must consult the Supports* methods
+// before exercising those scenarios.
+type webhookClientV1alpha1 struct {
+	client clientset.Interface
+}
+
+func (c *webhookClientV1alpha1) toWebhook(d webhookDescriptor) v1alpha1.Webhook {
+	ops := make([]v1alpha1.OperationType, 0, len(d.Operations))
+	for _, o := range d.Operations {
+		ops = append(ops, v1alpha1.OperationType(o))
+	}
+	return v1alpha1.Webhook{
+		Name: d.Name,
+		Rules: []v1alpha1.RuleWithOperations{{
+			Operations: ops,
+			Rule: v1alpha1.Rule{
+				APIGroups:   d.APIGroups,
+				APIVersions: d.APIVersions,
+				Resources:   d.Resources,
+			},
+		}},
+		FailurePolicy:     failurePolicyV1alpha1(d.FailurePolicyFail),
+		NamespaceSelector: d.NamespaceSelector,
+		ClientConfig: v1alpha1.WebhookClientConfig{
+			Service: &v1alpha1.ServiceReference{
+				Namespace: serviceNamespace,
+				Name:      serviceName,
+				Path:      strPtr(d.Path),
 			},
+			CABundle: d.CABundle,
 		},
-	})
+	}
+}
+
+func (c *webhookClientV1alpha1) CreateValidating(configName string, webhooks []webhookDescriptor) error {
+	config := &v1alpha1.ValidatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{Name: configName},
+	}
+	for _, d := range webhooks {
+		config.Webhooks = append(config.Webhooks, c.toWebhook(d))
+	}
+	_, err := c.client.AdmissionregistrationV1alpha1().ValidatingWebhookConfigurations().Create(config)
+	return err
+}
+
+func (c *webhookClientV1alpha1) CreateMutating(configName string, webhooks []webhookDescriptor) error {
+	return fmt.Errorf("MutatingWebhookConfiguration is not available in admissionregistration.k8s.io/v1alpha1")
+}
+
+func (c *webhookClientV1alpha1) Delete(configName string) error {
+	if err := c.client.AdmissionregistrationV1alpha1().ValidatingWebhookConfigurations().Delete(configName, nil); err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+func (c *webhookClientV1alpha1) SupportsObjectSelector() bool     { return false }
+func (c *webhookClientV1alpha1) SupportsMatchPolicy() bool        { return false }
+func (c *webhookClientV1alpha1) SupportsReinvocationPolicy() bool { return false }
+func (c *webhookClientV1alpha1) SupportsScope() bool              { return false }
+func (c *webhookClientV1alpha1) SupportsMutating() bool           { return false }
+
+func failurePolicyV1alpha1(fail bool) *v1alpha1.FailurePolicyType {
+	p := v1alpha1.Ignore
+	if fail {
+		p = v1alpha1.Fail
+	}
+	return &p
+}
+
+// serviceNamespace is set by deployWebhookAndService before any webhookClient
+// method is used; the sample webhook always runs in the test's own
+// namespace.
+var serviceNamespace string
+
+// webhookManifestParams are the values templated into the sample webhook's
+// static testdata manifests before they're decoded and applied. Keeping the
+// manifests on disk, rather than building the objects programmatically,
+// lets users who run the sample webhook out-of-tree diff exactly what the
+// e2e suite applies.
+type webhookManifestParams struct {
+	Namespace string
+	Image     string
+	TLSCert   string
+	TLSKey    string
+}
+
+// loadWebhookManifest renders the named file under
+// test/e2e/apimachinery/testdata/webhook as a Go template with params, then
+// decodes the result into obj.
+func loadWebhookManifest(name string, params webhookManifestParams, obj interface{}) error {
+	path := filepath.Join(framework.TestContext.RepoRoot, "test/e2e/apimachinery/testdata/webhook", name)
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading webhook manifest %s: %v", path, err)
+	}
+	tmpl, err := template.New(name).Parse(string(raw))
+	if err != nil {
+		return fmt.Errorf("parsing webhook manifest %s: %v", path, err)
+	}
+	var rendered bytes.Buffer
+	if err := tmpl.Execute(&rendered, params); err != nil {
+		return fmt.Errorf("templating webhook manifest %s: %v", path, err)
+	}
+	return yaml.NewYAMLOrJSONDecoder(&rendered, rendered.Len()).Decode(obj)
+}
+
+func createAuthReaderRoleBinding(f *framework.Framework, namespace string) {
+	By("Create role binding to let webhook read extension-apiserver-authentication")
+	client := f.ClientSet
+	// Create the role binding to allow the webhook read the extension-apiserver-authentication configmap
+	roleBinding := &rbacv1beta1.RoleBinding{}
+	err := loadWebhookManifest("rbac.yaml", webhookManifestParams{Namespace: namespace}, roleBinding)
+	framework.ExpectNoError(err, "loading webhook manifest rbac.yaml")
+	_, err = client.RbacV1beta1().RoleBindings("kube-system").Create(roleBinding)
 	if err != nil && errors.IsAlreadyExists(err) {
 		framework.Logf("role binding %s already exists", roleBindingName)
 	} else {
@@ -159,75 +601,26 @@ func deployWebhookAndService(f *framework.Framework, image string, context *cert
 	By("Deploying the webhook pod")
 	client := f.ClientSet
 
-	// Creating the secret that contains the webhook's cert.
-	secret := &v1.Secret{
-		ObjectMeta: metav1.ObjectMeta{
-			Name: secretName,
-		},
-		Type: v1.SecretTypeOpaque,
-		Data: map[string][]byte{
-			"tls.crt": context.cert,
-			"tls.key": context.key,
-		},
-	}
 	namespace := f.Namespace.Name
-	_, err := client.CoreV1().Secrets(namespace).Create(secret)
+	serviceNamespace = namespace
+	params := webhookManifestParams{
+		Namespace: namespace,
+		Image:     image,
+		TLSCert:   base64.StdEncoding.EncodeToString(context.cert),
+		TLSKey:    base64.StdEncoding.EncodeToString(context.key),
+	}
+
+	// Creating the secret that contains the webhook's cert.
+	secret := &v1.Secret{}
+	err := loadWebhookManifest("secret.yaml", params, secret)
+	framework.ExpectNoError(err, "loading webhook manifest secret.yaml")
+	_, err = client.CoreV1().Secrets(namespace).Create(secret)
 	framework.ExpectNoError(err, "creating secret %q in namespace %q", secretName, namespace)
 
 	// Create the deployment of the webhook
-	podLabels := map[string]string{"app": "sample-webhook", "webhook": "true"}
-	replicas := int32(1)
-	zero := int64(0)
-	mounts := []v1.VolumeMount{
-		{
-			Name:      "webhook-certs",
-			ReadOnly:  true,
-			MountPath: "/webhook.local.config/certificates",
-		},
-	}
-	volumes := []v1.Volume{
-		{
-			Name: "webhook-certs",
-			VolumeSource: v1.VolumeSource{
-				Secret: &v1.SecretVolumeSource{SecretName: secretName},
-			},
-		},
-	}
-	containers := []v1.Container{
-		{
-			Name:         "sample-webhook",
-			VolumeMounts: mounts,
-			Args: []string{
-				"--tls-cert-file=/webhook.local.config/certificates/tls.crt",
-				"--tls-private-key-file=/webhook.local.config/certificates/tls.key",
-				"--alsologtostderr",
-				"-v=4",
-				"2>&1",
-			},
-			Image: image,
-		},
-	}
-	d := &extensions.Deployment{
-		ObjectMeta: metav1.ObjectMeta{
-			Name: deploymentName,
-		},
-		Spec: extensions.DeploymentSpec{
-			Replicas: &replicas,
-			Strategy: extensions.DeploymentStrategy{
-				Type: extensions.RollingUpdateDeploymentStrategyType,
-			},
-			Template: v1.PodTemplateSpec{
-				ObjectMeta: metav1.ObjectMeta{
-					Labels: podLabels,
-				},
-				Spec: v1.PodSpec{
-					TerminationGracePeriodSeconds: &zero,
-					Containers:                    containers,
-					Volumes:                       volumes,
-				},
-			},
-		},
-	}
+	d := &extensions.Deployment{}
+	err = loadWebhookManifest("deployment.yaml", params, d)
+	framework.ExpectNoError(err, "loading webhook manifest deployment.yaml")
 	deployment, err := client.ExtensionsV1beta1().Deployments(namespace).Create(d)
 	framework.ExpectNoError(err, "creating deployment %s in namespace %s", deploymentName, namespace)
 	By("Wait for the deployment to be ready")
@@ -237,25 +630,9 @@ func deployWebhookAndService(f *framework.Framework, image string, context *cert
 	framework.ExpectNoError(err, "waiting for the deployment status valid", image, deploymentName, namespace)
 
 	By("Deploying the webhook service")
-
-	serviceLabels := map[string]string{"webhook": "true"}
-	service := &v1.Service{
-		ObjectMeta: metav1.ObjectMeta{
-			Namespace: namespace,
-			Name:      serviceName,
-			Labels:    map[string]string{"test": "webhook"},
-		},
-		Spec: v1.ServiceSpec{
-			Selector: serviceLabels,
-			Ports: []v1.ServicePort{
-				{
-					Protocol:   "TCP",
-					Port:       443,
-					TargetPort: intstr.FromInt(443),
-				},
-			},
-		},
-	}
+	service := &v1.Service{}
+	err = loadWebhookManifest("service.yaml", params, service)
+	framework.ExpectNoError(err, "loading webhook manifest service.yaml")
 	_, err = client.CoreV1().Services(namespace).Create(service)
 	framework.ExpectNoError(err, "creating service %s in namespace %s", serviceName, namespace)
 
@@ -266,78 +643,297 @@ func deployWebhookAndService(f *framework.Framework, image string, context *cert
 
 func strPtr(s string) *string { return &s }
 
-func registerWebhook(f *framework.Framework, context *certContext) {
-	client := f.ClientSet
+// waitForWebhookConfigurationReady polls probe until it reports the webhook
+// configuration is actively being enforced, or times out. This replaces a
+// fixed sleep after every webhook registration: propagation to all
+// apiserver instances is usually much faster than the worst case, and a
+// fixed sleep either wastes time or, under load, isn't long enough.
+func waitForWebhookConfigurationReady(configName string, probe wait.ConditionFunc) error {
+	By(fmt.Sprintf("waiting for webhook configuration %s to be honored", configName))
+	return wait.PollImmediate(1*time.Second, 30*time.Second, probe)
+}
+
+// requireNeverReady polls probe for window and fails as soon as probe
+// reports ready, instead of a single blind sleep. It's the negative-test
+// counterpart to waitForWebhookConfigurationReady, for asserting a rule is
+// never honored (e.g. a Namespaced-scoped rule must never intercept a
+// cluster-scoped object) rather than waiting for one that should be.
+func requireNeverReady(window time.Duration, probe wait.ConditionFunc) error {
+	deadline := time.Now().Add(window)
+	for time.Now().Before(deadline) {
+		ready, err := probe()
+		if err != nil {
+			return err
+		}
+		if ready {
+			return fmt.Errorf("probe unexpectedly became ready")
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+	return nil
+}
+
+// configMapDenialProbe returns a probe that repeatedly creates (and cleans
+// up) a canary configmap carrying the standard disallowed payload, and
+// reports ready once the webhook denies it.
+func configMapDenialProbe(c clientset.Interface, ns string) wait.ConditionFunc {
+	return func() (bool, error) {
+		cm, err := c.CoreV1().ConfigMaps(ns).Create(&v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{GenerateName: "webhook-probe-"},
+			Data:       map[string]string{"webhook-e2e-test": "webhook-disallow"},
+		})
+		if err == nil {
+			_ = c.CoreV1().ConfigMaps(ns).Delete(cm.Name, nil)
+			return false, nil
+		}
+		return strings.Contains(err.Error(), "the configmap contains unwanted key and value"), nil
+	}
+}
+
+// internalErrorProbe returns a probe that repeatedly creates a canary
+// configmap and reports ready once the call fails with an internal error,
+// the signature of a fail-closed webhook the apiserver cannot reach.
+func internalErrorProbe(c clientset.Interface, ns string) wait.ConditionFunc {
+	return func() (bool, error) {
+		_, err := c.CoreV1().ConfigMaps(ns).Create(&v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{GenerateName: "webhook-probe-"},
+		})
+		return err != nil && errors.IsInternalError(err), nil
+	}
+}
+
+// crdDenialProbe returns a probe that repeatedly creates a canary custom
+// resource carrying the standard disallowed payload, and reports ready once
+// the webhook denies it.
+func crdDenialProbe(crdClient dynamic.ResourceInterface) wait.ConditionFunc {
+	return func() (bool, error) {
+		_, err := crdClient.Create(&unstructured.Unstructured{
+			Object: map[string]interface{}{
+				"kind":       crdKind,
+				"apiVersion": crdAPIGroup + "/" + crdAPIVersion,
+				"metadata": map[string]interface{}{
+					"generateName": "webhook-probe-",
+				},
+				"data": map[string]interface{}{
+					"webhook-e2e-test": "webhook-disallow",
+				},
+			},
+		})
+		if err == nil {
+			return false, nil
+		}
+		return strings.Contains(err.Error(), "the custom resource contains unwanted data"), nil
+	}
+}
+
+// podMutationProbe returns a probe that repeatedly creates (and cleans up) a
+// canary pod, and reports ready once it comes back with containerName
+// already injected by a mutating webhook.
+func podMutationProbe(c clientset.Interface, ns, containerName string) wait.ConditionFunc {
+	return func() (bool, error) {
+		pod, err := c.CoreV1().Pods(ns).Create(&v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{GenerateName: "webhook-mutation-probe-"},
+			Spec: v1.PodSpec{
+				Containers: []v1.Container{{Name: "probe", Image: framework.GetPauseImageName(c)}},
+			},
+		})
+		if err != nil {
+			return false, nil
+		}
+		defer func() { _ = c.CoreV1().Pods(ns).Delete(pod.Name, nil) }()
+		for _, container := range pod.Spec.Containers {
+			if container.Name == containerName {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+}
+
+// configMapLabelDenialProbe returns a probe that repeatedly creates (and
+// cleans up) a canary configmap carrying labels, and reports ready once the
+// webhook denies it. Unlike configMapDenialProbe it doesn't check the
+// denial message, since the caller may be testing a webhook (like
+// /always-deny) whose rejection text isn't meaningful to match on.
+func configMapLabelDenialProbe(c clientset.Interface, ns string, labels map[string]string) wait.ConditionFunc {
+	return func() (bool, error) {
+		cm, err := c.CoreV1().ConfigMaps(ns).Create(&v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{GenerateName: "webhook-probe-", Labels: labels},
+		})
+		if err == nil {
+			_ = c.CoreV1().ConfigMaps(ns).Delete(cm.Name, nil)
+			return false, nil
+		}
+		return true, nil
+	}
+}
+
+// namespaceDenialProbe returns a probe that repeatedly creates (and cleans
+// up) a canary namespace, and reports ready once the create fails. Like
+// configMapLabelDenialProbe it doesn't inspect the error.
+func namespaceDenialProbe(c clientset.Interface) wait.ConditionFunc {
+	return func() (bool, error) {
+		ns, err := c.CoreV1().Namespaces().Create(&v1.Namespace{ObjectMeta: metav1.ObjectMeta{GenerateName: "webhook-scope-probe-"}})
+		if err == nil {
+			_ = c.CoreV1().Namespaces().Delete(ns.Name, nil)
+			return false, nil
+		}
+		return true, nil
+	}
+}
+
+// multiVersionCRDenialProbe returns a probe that repeatedly creates (and
+// cleans up) a canary custom resource at the given version, and reports
+// ready once the create fails. Like configMapLabelDenialProbe it doesn't
+// inspect the error.
+func multiVersionCRDenialProbe(crdClient dynamic.ResourceInterface, namespace, version string) wait.ConditionFunc {
+	return func() (bool, error) {
+		cr, err := createMultiVersionCRInstance(crdClient, namespace, version, "webhook-readiness-probe")
+		if err == nil {
+			_ = crdClient.Delete(cr.GetName(), nil)
+			return false, nil
+		}
+		return true, nil
+	}
+}
+
+// podAnnotationProbe returns a probe that repeatedly creates (and cleans up)
+// a canary pod, and reports ready once it comes back with annotation key
+// set to value by a mutating webhook.
+func podAnnotationProbe(c clientset.Interface, ns, key, value string) wait.ConditionFunc {
+	return func() (bool, error) {
+		pod, err := c.CoreV1().Pods(ns).Create(&v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{GenerateName: "webhook-annotation-probe-"},
+			Spec: v1.PodSpec{
+				Containers: []v1.Container{{Name: "probe", Image: framework.GetPauseImageName(c)}},
+			},
+		})
+		if err != nil {
+			return false, nil
+		}
+		defer func() { _ = c.CoreV1().Pods(ns).Delete(pod.Name, nil) }()
+		return pod.Annotations[key] == value, nil
+	}
+}
+
+// shouldRetryWebhookAPICall reports whether err looks like a transient
+// apiserver-side failure (a server timeout, service unavailable or too many
+// requests response, or a refused connection to the webhook's endpoint)
+// rather than the admission decision the test is asserting on. Internal
+// errors are not retried.
+func shouldRetryWebhookAPICall(err error) bool {
+	if err == nil {
+		return false
+	}
+	return errors.IsServerTimeout(err) || errors.IsServiceUnavailable(err) || errors.IsTooManyRequests(err) ||
+		strings.Contains(err.Error(), "connection refused")
+}
+
+// retryWebhookAPICall retries fn with backoff while shouldRetryWebhookAPICall
+// considers its error transient, so a brief apiserver 5xx or a webhook
+// endpoint that isn't quite ready yet doesn't fail the whole suite.
+func retryWebhookAPICall(fn func() error) error {
+	var lastErr error
+	backoff := wait.Backoff{Duration: 500 * time.Millisecond, Factor: 2, Steps: 5}
+	waitErr := wait.ExponentialBackoff(backoff, func() (bool, error) {
+		lastErr = fn()
+		if lastErr == nil {
+			return true, nil
+		}
+		if shouldRetryWebhookAPICall(lastErr) {
+			return false, nil
+		}
+		return false, lastErr
+	})
+	if waitErr == wait.ErrWaitTimeout {
+		return lastErr
+	}
+	if waitErr != nil {
+		return waitErr
+	}
+	return nil
+}
+
+// createConfigMapWithRetry is CoreV1().ConfigMaps().Create wrapped in
+// retryWebhookAPICall, for flaky apiserver calls.
+func createConfigMapWithRetry(c clientset.Interface, ns string, cm *v1.ConfigMap) (*v1.ConfigMap, error) {
+	var result *v1.ConfigMap
+	err := retryWebhookAPICall(func() error {
+		var err error
+		result, err = c.CoreV1().ConfigMaps(ns).Create(cm)
+		return err
+	})
+	return result, err
+}
+
+// createPodWithRetry is CoreV1().Pods().Create wrapped in
+// retryWebhookAPICall, for flaky apiserver calls.
+func createPodWithRetry(c clientset.Interface, ns string, pod *v1.Pod) (*v1.Pod, error) {
+	var result *v1.Pod
+	err := retryWebhookAPICall(func() error {
+		var err error
+		result, err = c.CoreV1().Pods(ns).Create(pod)
+		return err
+	})
+	return result, err
+}
+
+// getConfigMapWithRetry is CoreV1().ConfigMaps().Get wrapped in
+// retryWebhookAPICall, for flaky apiserver calls.
+func getConfigMapWithRetry(c clientset.Interface, ns, name string) (*v1.ConfigMap, error) {
+	var result *v1.ConfigMap
+	err := retryWebhookAPICall(func() error {
+		var err error
+		result, err = c.CoreV1().ConfigMaps(ns).Get(name, metav1.GetOptions{})
+		return err
+	})
+	return result, err
+}
+
+func registerWebhook(f *framework.Framework, wc webhookClient, context *certContext) {
 	By("Registering the webhook via the AdmissionRegistration API")
 
-	namespace := f.Namespace.Name
 	// A webhook that cannot talk to server, with fail-open policy
-	failOpenHook := failingWebhook(namespace, "fail-open.k8s.io")
-	policyIgnore := v1alpha1.Ignore
-	failOpenHook.FailurePolicy = &policyIgnore
+	failOpenHook := failingWebhookDescriptor("fail-open.k8s.io")
+	failOpenHook.FailurePolicyFail = false
 
-	_, err := client.AdmissionregistrationV1alpha1().ValidatingWebhookConfigurations().Create(&v1alpha1.ValidatingWebhookConfiguration{
-		ObjectMeta: metav1.ObjectMeta{
-			Name: webhookConfigName,
+	err := wc.CreateValidating(webhookConfigName, []webhookDescriptor{
+		{
+			Name:        "deny-unwanted-pod-container-name-and-label.k8s.io",
+			Path:        "/pods",
+			Operations:  []admissionregistrationv1.OperationType{admissionregistrationv1.Create},
+			APIGroups:   []string{""},
+			APIVersions: []string{"v1"},
+			Resources:   []string{"pods"},
+			CABundle:    context.signingCert,
 		},
-		Webhooks: []v1alpha1.Webhook{
-			{
-				Name: "deny-unwanted-pod-container-name-and-label.k8s.io",
-				Rules: []v1alpha1.RuleWithOperations{{
-					Operations: []v1alpha1.OperationType{v1alpha1.Create},
-					Rule: v1alpha1.Rule{
-						APIGroups:   []string{""},
-						APIVersions: []string{"v1"},
-						Resources:   []string{"pods"},
-					},
-				}},
-				ClientConfig: v1alpha1.WebhookClientConfig{
-					Service: &v1alpha1.ServiceReference{
-						Namespace: namespace,
-						Name:      serviceName,
-						Path:      strPtr("/pods"),
-					},
-					CABundle: context.signingCert,
-				},
-			},
-			{
-				Name: "deny-unwanted-configmap-data.k8s.io",
-				Rules: []v1alpha1.RuleWithOperations{{
-					Operations: []v1alpha1.OperationType{v1alpha1.Create, v1alpha1.Update},
-					Rule: v1alpha1.Rule{
-						APIGroups:   []string{""},
-						APIVersions: []string{"v1"},
-						Resources:   []string{"configmaps"},
-					},
-				}},
-				// The webhook skips the namespace that has label "skip-webhook-admission":"yes"
-				NamespaceSelector: &metav1.LabelSelector{
-					MatchExpressions: []metav1.LabelSelectorRequirement{
-						{
-							Key:      skipNamespaceLabelKey,
-							Operator: metav1.LabelSelectorOpNotIn,
-							Values:   []string{skipNamespaceLabelValue},
-						},
-					},
-				},
-				ClientConfig: v1alpha1.WebhookClientConfig{
-					Service: &v1alpha1.ServiceReference{
-						Namespace: namespace,
-						Name:      serviceName,
-						Path:      strPtr("/configmaps"),
+		{
+			Name:        "deny-unwanted-configmap-data.k8s.io",
+			Path:        "/configmaps",
+			Operations:  []admissionregistrationv1.OperationType{admissionregistrationv1.Create, admissionregistrationv1.Update},
+			APIGroups:   []string{""},
+			APIVersions: []string{"v1"},
+			Resources:   []string{"configmaps"},
+			// The webhook skips the namespace that has label "skip-webhook-admission":"yes"
+			NamespaceSelector: &metav1.LabelSelector{
+				MatchExpressions: []metav1.LabelSelectorRequirement{
+					{
+						Key:      skipNamespaceLabelKey,
+						Operator: metav1.LabelSelectorOpNotIn,
+						Values:   []string{skipNamespaceLabelValue},
 					},
-					CABundle: context.signingCert,
 				},
 			},
-			// Server cannot talk to this webhook, so it always fails.
-			// Because this webhook is configured fail-open, request should be admitted after the call fails.
-			failOpenHook,
+			CABundle: context.signingCert,
 		},
+		// Server cannot talk to this webhook, so it always fails.
+		// Because this webhook is configured fail-open, request should be admitted after the call fails.
+		failOpenHook,
 	})
-	framework.ExpectNoError(err, "registering webhook config %s with namespace %s", webhookConfigName, namespace)
+	framework.ExpectNoError(err, "registering webhook config %s", webhookConfigName)
 
-	// The webhook configuration is honored in 1s.
-	time.Sleep(10 * time.Second)
+	err = waitForWebhookConfigurationReady(webhookConfigName, configMapDenialProbe(f.ClientSet, f.Namespace.Name))
+	framework.ExpectNoError(err, "waiting for webhook config %s to be honored", webhookConfigName)
 }
 
 func testWebhook(f *framework.Framework) {
@@ -409,46 +1005,43 @@ func testWebhook(f *framework.Framework) {
 	}})
 	framework.ExpectNoError(err, "creating namespace %q", skippedNamespaceName)
 
-	By("create a configmap that violates the webhook policy but is in a whitelisted namespace")
-	configmap = nonCompliantConfigMap(f)
-	_, err = client.CoreV1().ConfigMaps(skippedNamespaceName).Create(configmap)
-	Expect(err).To(BeNil())
-}
-
-// failingWebhook returns a webhook with rule of create configmaps,
-// but with an invalid client config so that server cannot communicate with it
-func failingWebhook(namespace, name string) v1alpha1.Webhook {
-	return v1alpha1.Webhook{
-		Name: name,
-		Rules: []v1alpha1.RuleWithOperations{{
-			Operations: []v1alpha1.OperationType{v1alpha1.Create},
-			Rule: v1alpha1.Rule{
-				APIGroups:   []string{""},
-				APIVersions: []string{"v1"},
-				Resources:   []string{"configmaps"},
-			},
-		}},
-		ClientConfig: v1alpha1.WebhookClientConfig{
-			Service: &v1alpha1.ServiceReference{
-				Namespace: namespace,
-				Name:      serviceName,
-				Path:      strPtr("/configmaps"),
-			},
-			// Without CA bundle, the call to webhook always fails
-			CABundle: nil,
-		},
+	By("create a configmap that violates the webhook policy but is in a whitelisted namespace")
+	configmap = nonCompliantConfigMap(f)
+	_, err = client.CoreV1().ConfigMaps(skippedNamespaceName).Create(configmap)
+	Expect(err).To(BeNil())
+}
+
+// failingWebhookDescriptor returns a descriptor for a webhook with a rule on
+// create configmaps, but with an invalid client config so that server
+// cannot communicate with it.
+func failingWebhookDescriptor(name string) webhookDescriptor {
+	return webhookDescriptor{
+		Name:        name,
+		Path:        "/configmaps",
+		Operations:  []admissionregistrationv1.OperationType{admissionregistrationv1.Create},
+		APIGroups:   []string{""},
+		APIVersions: []string{"v1"},
+		Resources:   []string{"configmaps"},
+		// Without CA bundle, the call to webhook always fails
+		CABundle: nil,
 	}
 }
 
-func registerFailClosedWebhook(f *framework.Framework, context *certContext) {
-	client := f.ClientSet
+func registerFailClosedWebhook(f *framework.Framework, wc webhookClient, context *certContext) {
+	By("create a namespace for the fail closed webhook")
+	err := createNamespace(f, &v1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name: failNamespaceName,
+		Labels: map[string]string{
+			failNamespaceLabelKey: failNamespaceLabelValue,
+		},
+	}})
+	framework.ExpectNoError(err, "creating namespace %q", failNamespaceName)
+
 	By("Registering a webhook that server cannot talk to, with fail closed policy, via the AdmissionRegistration API")
 
-	namespace := f.Namespace.Name
 	// A webhook that cannot talk to server, with fail-closed policy
-	policyFail := v1alpha1.Fail
-	hook := failingWebhook(namespace, "fail-closed.k8s.io")
-	hook.FailurePolicy = &policyFail
+	hook := failingWebhookDescriptor("fail-closed.k8s.io")
+	hook.FailurePolicyFail = true
 	hook.NamespaceSelector = &metav1.LabelSelector{
 		MatchExpressions: []metav1.LabelSelectorRequirement{
 			{
@@ -459,40 +1052,25 @@ func registerFailClosedWebhook(f *framework.Framework, context *certContext) {
 		},
 	}
 
-	_, err := client.AdmissionregistrationV1alpha1().ValidatingWebhookConfigurations().Create(&v1alpha1.ValidatingWebhookConfiguration{
-		ObjectMeta: metav1.ObjectMeta{
-			Name: webhookFailClosedConfigName,
-		},
-		Webhooks: []v1alpha1.Webhook{
-			// Server cannot talk to this webhook, so it always fails.
-			// Because this webhook is configured fail-closed, request should be rejected after the call fails.
-			hook,
-		},
+	err = wc.CreateValidating(webhookFailClosedConfigName, []webhookDescriptor{
+		// Server cannot talk to this webhook, so it always fails.
+		// Because this webhook is configured fail-closed, request should be rejected after the call fails.
+		hook,
 	})
-	framework.ExpectNoError(err, "registering webhook config %s with namespace %s", webhookFailClosedConfigName, namespace)
+	framework.ExpectNoError(err, "registering webhook config %s", webhookFailClosedConfigName)
 
-	// The webhook configuration is honored in 10s.
-	time.Sleep(10 * time.Second)
+	err = waitForWebhookConfigurationReady(webhookFailClosedConfigName, internalErrorProbe(f.ClientSet, failNamespaceName))
+	framework.ExpectNoError(err, "waiting for webhook config %s to be honored", webhookFailClosedConfigName)
 }
 
 func testFailClosedWebhook(f *framework.Framework) {
-	client := f.ClientSet
-	By("create a namespace for the webhook")
-	err := createNamespace(f, &v1.Namespace{ObjectMeta: metav1.ObjectMeta{
-		Name: failNamespaceName,
-		Labels: map[string]string{
-			failNamespaceLabelKey: failNamespaceLabelValue,
-		},
-	}})
-	framework.ExpectNoError(err, "creating namespace %q", failNamespaceName)
-
 	By("create a configmap should be unconditionally rejected by the webhook")
 	configmap := &v1.ConfigMap{
 		ObjectMeta: metav1.ObjectMeta{
 			Name: "foo",
 		},
 	}
-	_, err = client.CoreV1().ConfigMaps(failNamespaceName).Create(configmap)
+	_, err := createConfigMapWithRetry(f.ClientSet, failNamespaceName, configmap)
 	Expect(err).To(HaveOccurred())
 	if !errors.IsInternalError(err) {
 		framework.Failf("expect an internal error, got %#v", err)
@@ -552,7 +1130,7 @@ func updateConfigMap(c clientset.Interface, ns, name string, update updateConfig
 	var cm *v1.ConfigMap
 	pollErr := wait.PollImmediate(2*time.Second, 1*time.Minute, func() (bool, error) {
 		var err error
-		if cm, err = c.CoreV1().ConfigMaps(ns).Get(name, metav1.GetOptions{}); err != nil {
+		if cm, err = getConfigMapWithRetry(c, ns, name); err != nil {
 			return false, err
 		}
 		update(cm)
@@ -568,9 +1146,17 @@ func updateConfigMap(c clientset.Interface, ns, name string, update updateConfig
 	return cm, pollErr
 }
 
-func cleanWebhookTest(client clientset.Interface, namespaceName string) {
-	_ = client.AdmissionregistrationV1alpha1().ValidatingWebhookConfigurations().Delete(webhookConfigName, nil)
-	_ = client.AdmissionregistrationV1alpha1().ValidatingWebhookConfigurations().Delete(crdWebhookConfigName, nil)
+func cleanWebhookTest(wc webhookClient, client clientset.Interface, namespaceName string) {
+	_ = wc.Delete(webhookConfigName)
+	_ = wc.Delete(crdWebhookConfigName)
+	_ = wc.Delete(mutatingWebhookConfigName)
+	_ = wc.Delete(mutationChainConfigName)
+	_ = wc.Delete(objectSelectorConfigName)
+	_ = wc.Delete(scopeConfigName)
+	_ = wc.Delete(matchPolicyConfigName)
+	_ = wc.Delete(systemNamespaceSelectorConfigName)
+	_ = wc.Delete(reinvocationConfigName)
+	_ = wc.Delete(reinvocationBuggyConfigName)
 	_ = client.CoreV1().Services(namespaceName).Delete(serviceName, nil)
 	_ = client.ExtensionsV1beta1().Deployments(namespaceName).Delete(deploymentName, nil)
 	_ = client.CoreV1().Secrets(namespaceName).Delete(secretName, nil)
@@ -630,41 +1216,24 @@ func createCRD(f *framework.Framework) (func(), dynamic.ResourceInterface) {
 	}, resourceClient
 }
 
-func registerWebhookForCRD(f *framework.Framework, context *certContext) {
-	client := f.ClientSet
+func registerWebhookForCRD(wc webhookClient, context *certContext, crdClient dynamic.ResourceInterface) {
 	By("Registering the crd webhook via the AdmissionRegistration API")
 
-	namespace := f.Namespace.Name
-	_, err := client.AdmissionregistrationV1alpha1().ValidatingWebhookConfigurations().Create(&v1alpha1.ValidatingWebhookConfiguration{
-		ObjectMeta: metav1.ObjectMeta{
-			Name: crdWebhookConfigName,
-		},
-		Webhooks: []v1alpha1.Webhook{
-			{
-				Name: "deny-unwanted-crd-data.k8s.io",
-				Rules: []v1alpha1.RuleWithOperations{{
-					Operations: []v1alpha1.OperationType{v1alpha1.Create},
-					Rule: v1alpha1.Rule{
-						APIGroups:   []string{crdAPIGroup},
-						APIVersions: []string{crdAPIVersion},
-						Resources:   []string{crdName + "s"},
-					},
-				}},
-				ClientConfig: v1alpha1.WebhookClientConfig{
-					Service: &v1alpha1.ServiceReference{
-						Namespace: namespace,
-						Name:      serviceName,
-						Path:      strPtr("/crd"),
-					},
-					CABundle: context.signingCert,
-				},
-			},
+	err := wc.CreateValidating(crdWebhookConfigName, []webhookDescriptor{
+		{
+			Name:        "deny-unwanted-crd-data.k8s.io",
+			Path:        "/crd",
+			Operations:  []admissionregistrationv1.OperationType{admissionregistrationv1.Create},
+			APIGroups:   []string{crdAPIGroup},
+			APIVersions: []string{crdAPIVersion},
+			Resources:   []string{crdName + "s"},
+			CABundle:    context.signingCert,
 		},
 	})
-	framework.ExpectNoError(err, "registering crd webhook config %s with namespace %s", webhookConfigName, namespace)
+	framework.ExpectNoError(err, "registering crd webhook config %s", crdWebhookConfigName)
 
-	// The webhook configuration is honored in 1s.
-	time.Sleep(10 * time.Second)
+	err = waitForWebhookConfigurationReady(crdWebhookConfigName, crdDenialProbe(crdClient))
+	framework.ExpectNoError(err, "waiting for webhook config %s to be honored", crdWebhookConfigName)
 }
 
 func testCRDWebhook(f *framework.Framework, crdClient dynamic.ResourceInterface) {
@@ -690,3 +1259,579 @@ func testCRDWebhook(f *framework.Framework, crdClient dynamic.ResourceInterface)
 		framework.Failf("expect error contains %q, got %q", expectedErrMsg, err.Error())
 	}
 }
+
+// registerMutatingWebhook registers a MutatingWebhookConfiguration with two
+// webhooks: one that injects a sidecar container and a label into pods, and
+// one that stamps a marker key into configmaps. Both point at the sample
+// webhook's mutating endpoints. Skips the test if the discovered
+// webhookClient doesn't support mutating webhooks (v1alpha1 clusters).
+func registerMutatingWebhook(f *framework.Framework, wc webhookClient, context *certContext) {
+	if !wc.SupportsMutating() {
+		framework.Skipf("MutatingWebhookConfiguration is not supported by the discovered admissionregistration API version")
+	}
+
+	By("Registering the mutating webhooks via the AdmissionRegistration API")
+
+	err := wc.CreateMutating(mutatingWebhookConfigName, []webhookDescriptor{
+		{
+			Name:        "adding-sidecar-container-and-label.k8s.io",
+			Path:        "/mutating-pods",
+			Operations:  []admissionregistrationv1.OperationType{admissionregistrationv1.Create},
+			APIGroups:   []string{""},
+			APIVersions: []string{"v1"},
+			Resources:   []string{"pods"},
+			CABundle:    context.signingCert,
+		},
+		{
+			Name:        "adding-configmap-data.k8s.io",
+			Path:        "/mutating-configmaps",
+			Operations:  []admissionregistrationv1.OperationType{admissionregistrationv1.Create},
+			APIGroups:   []string{""},
+			APIVersions: []string{"v1"},
+			Resources:   []string{"configmaps"},
+			CABundle:    context.signingCert,
+		},
+	})
+	framework.ExpectNoError(err, "registering mutating webhook config %s", mutatingWebhookConfigName)
+
+	err = waitForWebhookConfigurationReady(mutatingWebhookConfigName, podMutationProbe(f.ClientSet, f.Namespace.Name, addedSidecarContainerName))
+	framework.ExpectNoError(err, "waiting for webhook config %s to be honored", mutatingWebhookConfigName)
+}
+
+func testMutatingWebhook(f *framework.Framework) {
+	client := f.ClientSet
+	By("create a pod that should be mutated by the webhook")
+	pod := toBeMutatedPod(f)
+	createdPod, err := createPodWithRetry(client, f.Namespace.Name, pod)
+	Expect(err).NotTo(HaveOccurred())
+	var hasSidecar bool
+	for _, c := range createdPod.Spec.Containers {
+		if c.Name == addedSidecarContainerName {
+			hasSidecar = true
+		}
+	}
+	if !hasSidecar {
+		framework.Failf("expect pod to have container %s, got %#v", addedSidecarContainerName, createdPod.Spec.Containers)
+	}
+	if createdPod.Labels[addedLabelKey] != addedLabelValue {
+		framework.Failf("expect pod to have label %s=%s, got %#v", addedLabelKey, addedLabelValue, createdPod.Labels)
+	}
+
+	By("create a configmap that should be mutated by the webhook")
+	configmap := &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: allowedConfigMapName,
+		},
+		Data: map[string]string{
+			"mutation-start": "yes",
+		},
+	}
+	createdConfigMap, err := createConfigMapWithRetry(client, f.Namespace.Name, configmap)
+	Expect(err).NotTo(HaveOccurred())
+	if createdConfigMap.Data[addedConfigMapDataKey] != addedConfigMapDataValue {
+		framework.Failf("expect configmap to have data %s=%s, got %#v", addedConfigMapDataKey, addedConfigMapDataValue, createdConfigMap.Data)
+	}
+}
+
+func toBeMutatedPod(f *framework.Framework) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "to-be-mutated-pod",
+		},
+		Spec: v1.PodSpec{
+			Containers: []v1.Container{
+				{
+					Name:  "example",
+					Image: framework.GetPauseImageName(f.ClientSet),
+				},
+			},
+		},
+	}
+}
+
+// registerMutationChainWebhook registers two mutating webhooks on the same
+// rule, in order, so that webhook A's output is visible to webhook B: A adds
+// annotation "foo=1", B overwrites it to "foo=2".
+func registerMutationChainWebhook(f *framework.Framework, wc webhookClient, context *certContext) {
+	if !wc.SupportsMutating() {
+		framework.Skipf("MutatingWebhookConfiguration is not supported by the discovered admissionregistration API version")
+	}
+
+	By("Registering ordered mutating webhooks that both touch the same annotation")
+
+	rule := webhookDescriptor{
+		Operations:  []admissionregistrationv1.OperationType{admissionregistrationv1.Create},
+		APIGroups:   []string{""},
+		APIVersions: []string{"v1"},
+		Resources:   []string{"pods"},
+		CABundle:    context.signingCert,
+	}
+	a, b := rule, rule
+	a.Name, a.Path = "a.mutation-chain.k8s.io", "/mutation-chain-a"
+	b.Name, b.Path = "b.mutation-chain.k8s.io", "/mutation-chain-b"
+
+	err := wc.CreateMutating(mutationChainConfigName, []webhookDescriptor{a, b})
+	framework.ExpectNoError(err, "registering mutation chain webhook config %s", mutationChainConfigName)
+
+	err = waitForWebhookConfigurationReady(mutationChainConfigName, podAnnotationProbe(f.ClientSet, f.Namespace.Name, mutationAnnotationKey, "2"))
+	framework.ExpectNoError(err, "waiting for webhook config %s to be honored", mutationChainConfigName)
+}
+
+// registerValidatingWebhookForMutationChain registers a validating webhook
+// that rejects the pod unless it observes the final state left behind by the
+// ordered mutating webhooks, proving mutations are visible further down the
+// admission chain.
+func registerValidatingWebhookForMutationChain(f *framework.Framework, wc webhookClient, context *certContext) {
+	By("Registering a validating webhook that checks the result of the mutation chain")
+
+	err := wc.CreateValidating(mutationChainConfigName, []webhookDescriptor{
+		{
+			Name:        "validate-mutation-chain.k8s.io",
+			Path:        "/validate-mutation-chain",
+			Operations:  []admissionregistrationv1.OperationType{admissionregistrationv1.Create},
+			APIGroups:   []string{""},
+			APIVersions: []string{"v1"},
+			Resources:   []string{"pods"},
+			CABundle:    context.signingCert,
+		},
+	})
+	framework.ExpectNoError(err, "registering validating webhook config %s", mutationChainConfigName)
+
+	// The mutating webhooks above are already confirmed active, so every
+	// probe pod already carries the chain's final annotation. The probe
+	// therefore only confirms such pods are still admitted; it passes
+	// whether or not this validating webhook is being called yet.
+	err = waitForWebhookConfigurationReady(mutationChainConfigName, podAnnotationProbe(f.ClientSet, f.Namespace.Name, mutationAnnotationKey, "2"))
+	framework.ExpectNoError(err, "waiting for webhook config %s to be honored", mutationChainConfigName)
+}
+
+// testMutationChainOrder verifies that two ordered mutating webhooks compose
+// correctly, and that the resulting object is visible to a validating
+// webhook in the same admission chain.
+func testMutationChainOrder(f *framework.Framework, wc webhookClient, context *certContext) {
+	registerMutationChainWebhook(f, wc, context)
+	registerValidatingWebhookForMutationChain(f, wc, context)
+	defer func() {
+		_ = wc.Delete(mutationChainConfigName)
+	}()
+
+	By("create a pod that is mutated by webhook A then webhook B")
+	client := f.ClientSet
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: mutationChainPodName,
+		},
+		Spec: v1.PodSpec{
+			Containers: []v1.Container{
+				{
+					Name:  "example",
+					Image: framework.GetPauseImageName(f.ClientSet),
+				},
+			},
+		},
+	}
+	createdPod, err := client.CoreV1().Pods(f.Namespace.Name).Create(pod)
+	framework.ExpectNoError(err, "creating pod %s in namespace %s", mutationChainPodName, f.Namespace.Name)
+
+	// Webhook A sets foo=1, webhook B overwrites it to foo=2. If ordering
+	// were broken, the annotation would have been left at "1", and the
+	// validating webhook that only admits foo=2 would have rejected the
+	// request above.
+	if createdPod.Annotations[mutationAnnotationKey] != "2" {
+		framework.Failf("expect pod annotation %s=2 after mutation chain, got %#v", mutationAnnotationKey, createdPod.Annotations)
+	}
+}
+
+// testObjectSelector registers a webhook with an objectSelector that only
+// matches objects carrying label webhook-e2e-test=match, and verifies that
+// objects without the label bypass it entirely.
+func testObjectSelector(f *framework.Framework, wc webhookClient, context *certContext) {
+	if !wc.SupportsObjectSelector() {
+		framework.Skipf("objectSelector is not supported by the discovered admissionregistration API version")
+	}
+
+	By("Registering a webhook with an objectSelector on configmaps")
+	err := wc.CreateValidating(objectSelectorConfigName, []webhookDescriptor{
+		{
+			Name:        "deny-selected-configmaps.k8s.io",
+			Path:        "/always-deny",
+			Operations:  []admissionregistrationv1.OperationType{admissionregistrationv1.Create},
+			APIGroups:   []string{""},
+			APIVersions: []string{"v1"},
+			Resources:   []string{"configmaps"},
+			ObjectSelector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{objectSelectorLabelKey: objectSelectorLabelValue},
+			},
+			CABundle: context.signingCert,
+		},
+	})
+	framework.ExpectNoError(err, "registering webhook config %s", objectSelectorConfigName)
+	defer func() { _ = wc.Delete(objectSelectorConfigName) }()
+	err = waitForWebhookConfigurationReady(objectSelectorConfigName, configMapLabelDenialProbe(f.ClientSet, f.Namespace.Name, map[string]string{objectSelectorLabelKey: objectSelectorLabelValue}))
+	framework.ExpectNoError(err, "waiting for webhook config %s to be honored", objectSelectorConfigName)
+
+	By("create a configmap without the selected label, it should bypass the webhook")
+	_, err = f.ClientSet.CoreV1().ConfigMaps(f.Namespace.Name).Create(&v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "object-selector-no-match"},
+	})
+	framework.ExpectNoError(err, "creating configmap that does not match the objectSelector")
+
+	By("create a configmap with the selected label, it should be denied by the webhook")
+	_, err = f.ClientSet.CoreV1().ConfigMaps(f.Namespace.Name).Create(&v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   "object-selector-match",
+			Labels: map[string]string{objectSelectorLabelKey: objectSelectorLabelValue},
+		},
+	})
+	Expect(err).To(HaveOccurred())
+}
+
+// testScopeMatching proves that a rule scoped to Namespaced resources does
+// not match the (cluster-scoped) creation of a Namespace object, while the
+// same rule scoped to Cluster does.
+func testScopeMatching(f *framework.Framework, wc webhookClient, context *certContext) {
+	if !wc.SupportsScope() {
+		framework.Skipf("rule scope is not supported by the discovered admissionregistration API version")
+	}
+
+	namespacedScope := admissionregistrationv1.NamespacedScope
+	rule := webhookDescriptor{
+		Name:        "deny-namespaces.k8s.io",
+		Path:        "/always-deny",
+		Operations:  []admissionregistrationv1.OperationType{admissionregistrationv1.Create},
+		APIGroups:   []string{""},
+		APIVersions: []string{"v1"},
+		Resources:   []string{"namespaces"},
+		Scope:       &namespacedScope,
+		CABundle:    context.signingCert,
+	}
+
+	By("Registering a Namespaced-scoped rule on namespaces")
+	err := wc.CreateValidating(scopeConfigName, []webhookDescriptor{rule})
+	framework.ExpectNoError(err, "registering webhook config %s", scopeConfigName)
+	// A Namespaced-scoped rule must never match the cluster-scoped probe
+	// below; poll for a window instead of guessing a propagation delay, and
+	// fail fast if the rule is incorrectly honored for a cluster-scoped
+	// object.
+	err = requireNeverReady(5*time.Second, namespaceDenialProbe(f.ClientSet))
+	framework.ExpectNoError(err, "verifying webhook config %s does not intercept cluster-scoped namespaces", scopeConfigName)
+
+	By("create a namespace; a Namespaced-scoped rule must not intercept a cluster-scoped object")
+	err = createNamespace(f, &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: scopeNamespacedTestNamespaceName}})
+	framework.ExpectNoError(err, "creating namespace %q", scopeNamespacedTestNamespaceName)
+	_ = f.ClientSet.CoreV1().Namespaces().Delete(scopeNamespacedTestNamespaceName, nil)
+	_ = wc.Delete(scopeConfigName)
+
+	By("Registering the same rule with Cluster scope")
+	clusterScope := admissionregistrationv1.ClusterScope
+	rule.Scope = &clusterScope
+	err = wc.CreateValidating(scopeConfigName, []webhookDescriptor{rule})
+	framework.ExpectNoError(err, "registering webhook config %s", scopeConfigName)
+	defer func() { _ = wc.Delete(scopeConfigName) }()
+	err = waitForWebhookConfigurationReady(scopeConfigName, namespaceDenialProbe(f.ClientSet))
+	framework.ExpectNoError(err, "waiting for webhook config %s to be honored", scopeConfigName)
+
+	By("create a namespace; a Cluster-scoped rule must intercept it")
+	err = createNamespace(f, &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: scopeClusterTestNamespaceName}})
+	Expect(err).To(HaveOccurred())
+}
+
+// newMultiVersionCRDForMatchPolicyTest generates a CRD served under two
+// versions, so matchPolicy Equivalent vs Exact can be distinguished.
+func newMultiVersionCRDForMatchPolicyTest() *apiextensionsv1beta1.CustomResourceDefinition {
+	return &apiextensionsv1beta1.CustomResourceDefinition{
+		ObjectMeta: metav1.ObjectMeta{Name: matchPolicyCRDName + "s." + matchPolicyCRDGroup},
+		Spec: apiextensionsv1beta1.CustomResourceDefinitionSpec{
+			Group:   matchPolicyCRDGroup,
+			Version: matchPolicyCRDVersionV1,
+			Versions: []apiextensionsv1beta1.CustomResourceDefinitionVersion{
+				{Name: matchPolicyCRDVersionV1, Served: true, Storage: true},
+				{Name: matchPolicyCRDVersionV2, Served: true, Storage: false},
+			},
+			Names: apiextensionsv1beta1.CustomResourceDefinitionNames{
+				Plural:   matchPolicyCRDName + "s",
+				Singular: matchPolicyCRDName,
+				Kind:     matchPolicyCRDKind,
+				ListKind: matchPolicyCRDName + "List",
+			},
+			Scope: apiextensionsv1beta1.NamespaceScoped,
+		},
+	}
+}
+
+func createMultiVersionCRD(f *framework.Framework) (func(), dynamic.ResourceInterface) {
+	config, err := framework.LoadConfig()
+	if err != nil {
+		framework.Failf("failed to load config: %v", err)
+	}
+
+	apiExtensionClient, err := crdclientset.NewForConfig(config)
+	if err != nil {
+		framework.Failf("failed to initialize apiExtensionClient: %v", err)
+	}
+
+	crd := newMultiVersionCRDForMatchPolicyTest()
+
+	dynamicClient, err := testserver.CreateNewCustomResourceDefinitionWatchUnsafe(crd, apiExtensionClient, f.ClientPool)
+	if err != nil {
+		framework.Failf("failed to create CustomResourceDefinition: %v", err)
+	}
+
+	resourceClient := dynamicClient.Resource(&metav1.APIResource{
+		Name:       crd.Spec.Names.Plural,
+		Namespaced: true,
+	}, f.Namespace.Name)
+
+	return func() {
+		err = testserver.DeleteCustomResourceDefinition(crd, apiExtensionClient)
+		if err != nil {
+			framework.Failf("failed to delete CustomResourceDefinition: %v", err)
+		}
+	}, resourceClient
+}
+
+func createMultiVersionCRInstance(crdClient dynamic.ResourceInterface, namespace, version, name string) (*unstructured.Unstructured, error) {
+	crInstance := &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"kind":       matchPolicyCRDKind,
+			"apiVersion": matchPolicyCRDGroup + "/" + version,
+			"metadata": map[string]interface{}{
+				"name":      name,
+				"namespace": namespace,
+			},
+		},
+	}
+	return crdClient.Create(crInstance)
+}
+
+// testMatchPolicy registers the same rule against a single explicit CRD
+// version, once with matchPolicy Equivalent and once with Exact, and
+// verifies Equivalent reaches both served versions while Exact only reaches
+// the one it names.
+func testMatchPolicy(f *framework.Framework, wc webhookClient, context *certContext) {
+	if !wc.SupportsMatchPolicy() {
+		framework.Skipf("matchPolicy is not supported by the discovered admissionregistration API version")
+	}
+
+	crdCleanup, dynamicClient := createMultiVersionCRD(f)
+	defer crdCleanup()
+
+	rule := webhookDescriptor{
+		Name:        "deny-match-policy-crd.k8s.io",
+		Path:        "/always-deny",
+		Operations:  []admissionregistrationv1.OperationType{admissionregistrationv1.Create},
+		APIGroups:   []string{matchPolicyCRDGroup},
+		APIVersions: []string{matchPolicyCRDVersionV1},
+		Resources:   []string{matchPolicyCRDName + "s"},
+		CABundle:    context.signingCert,
+	}
+
+	By("Registering an Equivalent-matchPolicy webhook that only lists the v1 version explicitly")
+	equivalent := admissionregistrationv1.Equivalent
+	rule.MatchPolicy = &equivalent
+	err := wc.CreateValidating(matchPolicyConfigName, []webhookDescriptor{rule})
+	framework.ExpectNoError(err, "registering webhook config %s", matchPolicyConfigName)
+	err = waitForWebhookConfigurationReady(matchPolicyConfigName, multiVersionCRDenialProbe(dynamicClient, f.Namespace.Name, matchPolicyCRDVersionV1))
+	framework.ExpectNoError(err, "waiting for webhook config %s to be honored", matchPolicyConfigName)
+
+	By("create a v2 custom resource; Equivalent matchPolicy must still intercept it")
+	_, err = createMultiVersionCRInstance(dynamicClient, f.Namespace.Name, matchPolicyCRDVersionV2, "equivalent-instance")
+	Expect(err).To(HaveOccurred())
+	_ = wc.Delete(matchPolicyConfigName)
+
+	By("Registering the same rule with Exact matchPolicy")
+	exact := admissionregistrationv1.Exact
+	rule.MatchPolicy = &exact
+	err = wc.CreateValidating(matchPolicyConfigName, []webhookDescriptor{rule})
+	framework.ExpectNoError(err, "registering webhook config %s", matchPolicyConfigName)
+	defer func() { _ = wc.Delete(matchPolicyConfigName) }()
+	err = waitForWebhookConfigurationReady(matchPolicyConfigName, multiVersionCRDenialProbe(dynamicClient, f.Namespace.Name, matchPolicyCRDVersionV1))
+	framework.ExpectNoError(err, "waiting for webhook config %s to be honored", matchPolicyConfigName)
+
+	By("create a v1 custom resource; Exact matchPolicy intercepts the version it lists")
+	_, err = createMultiVersionCRInstance(dynamicClient, f.Namespace.Name, matchPolicyCRDVersionV1, "exact-instance-v1")
+	Expect(err).To(HaveOccurred())
+
+	By("create a v2 custom resource; Exact matchPolicy must not intercept a version it doesn't list")
+	_, err = createMultiVersionCRInstance(dynamicClient, f.Namespace.Name, matchPolicyCRDVersionV2, "exact-instance-v2")
+	Expect(err).NotTo(HaveOccurred())
+}
+
+// testNamespaceSelectorScopedToSystemNamespaces is a regression test for
+// overly broad webhooks: a webhook whose namespaceSelector only matches
+// namespaces labeled as system namespaces must never fire for an ordinary
+// user namespace.
+func testNamespaceSelectorScopedToSystemNamespaces(f *framework.Framework, wc webhookClient, context *certContext) {
+	By("Registering a webhook whose namespaceSelector only matches system-labeled namespaces")
+	err := wc.CreateValidating(systemNamespaceSelectorConfigName, []webhookDescriptor{
+		{
+			Name:        "deny-in-system-namespaces-only.k8s.io",
+			Path:        "/always-deny",
+			Operations:  []admissionregistrationv1.OperationType{admissionregistrationv1.Create},
+			APIGroups:   []string{""},
+			APIVersions: []string{"v1"},
+			Resources:   []string{"configmaps"},
+			NamespaceSelector: &metav1.LabelSelector{
+				MatchExpressions: []metav1.LabelSelectorRequirement{
+					{
+						Key:      systemNamespaceLabelKey,
+						Operator: metav1.LabelSelectorOpIn,
+						Values:   []string{systemNamespaceLabelValue},
+					},
+				},
+			},
+			CABundle: context.signingCert,
+		},
+	})
+	framework.ExpectNoError(err, "registering webhook config %s", systemNamespaceSelectorConfigName)
+	defer func() { _ = wc.Delete(systemNamespaceSelectorConfigName) }()
+
+	By("create a namespace labeled like a system namespace, to use as a readiness probe target")
+	err = createNamespace(f, &v1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name:   systemLikeNamespaceName,
+		Labels: map[string]string{systemNamespaceLabelKey: systemNamespaceLabelValue},
+	}})
+	framework.ExpectNoError(err, "creating namespace %q", systemLikeNamespaceName)
+	defer func() { _ = f.ClientSet.CoreV1().Namespaces().Delete(systemLikeNamespaceName, nil) }()
+
+	err = waitForWebhookConfigurationReady(systemNamespaceSelectorConfigName, configMapLabelDenialProbe(f.ClientSet, systemLikeNamespaceName, nil))
+	framework.ExpectNoError(err, "waiting for webhook config %s to be honored", systemNamespaceSelectorConfigName)
+
+	By("create a configmap in the ordinary user namespace; the system-scoped webhook must not intercept it")
+	_, err = f.ClientSet.CoreV1().ConfigMaps(f.Namespace.Name).Create(&v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "user-namespace-configmap"},
+	})
+	framework.ExpectNoError(err, "creating configmap in user namespace %s should not be intercepted by a system-scoped webhook", f.Namespace.Name)
+
+	By("confirm the system-labeled namespace is intercepted by the webhook")
+	_, err = f.ClientSet.CoreV1().ConfigMaps(systemLikeNamespaceName).Create(&v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "system-namespace-configmap"},
+	})
+	Expect(err).To(HaveOccurred())
+}
+
+// reinvocationIfNeeded is a small helper so every reinvocation-policy
+// registration in this file spells the policy the same way.
+func reinvocationIfNeeded() *admissionregistrationv1.ReinvocationPolicyType {
+	p := admissionregistrationv1.IfNeededReinvocationPolicy
+	return &p
+}
+
+// testMutatingWebhookReinvocation registers two mutating webhooks, both with
+// reinvocationPolicy IfNeeded: webhook A adds sidecar-a if it isn't already
+// present, webhook B adds sidecar-b if it isn't already present. Because B
+// mutates the object, the admission chain must reinvoke A; a correct A
+// observes that its previous mutation already landed and does not add a
+// second sidecar-a. The resulting pod must carry exactly one of each
+// sidecar.
+func testMutatingWebhookReinvocation(f *framework.Framework, wc webhookClient, context *certContext) {
+	if !wc.SupportsReinvocationPolicy() {
+		framework.Skipf("reinvocationPolicy is not supported by the discovered admissionregistration API version")
+	}
+
+	By("Registering two mutating webhooks with reinvocationPolicy IfNeeded")
+	rule := webhookDescriptor{
+		Operations:         []admissionregistrationv1.OperationType{admissionregistrationv1.Create},
+		APIGroups:          []string{""},
+		APIVersions:        []string{"v1"},
+		Resources:          []string{"pods"},
+		ReinvocationPolicy: reinvocationIfNeeded(),
+		CABundle:           context.signingCert,
+	}
+	a, b := rule, rule
+	a.Name, a.Path = "a.reinvocation.k8s.io", "/mutation-reinvocation-a"
+	b.Name, b.Path = "b.reinvocation.k8s.io", "/mutation-reinvocation-b"
+
+	err := wc.CreateMutating(reinvocationConfigName, []webhookDescriptor{a, b})
+	framework.ExpectNoError(err, "registering mutating webhook config %s", reinvocationConfigName)
+	defer func() { _ = wc.Delete(reinvocationConfigName) }()
+	err = waitForWebhookConfigurationReady(reinvocationConfigName, podMutationProbe(f.ClientSet, f.Namespace.Name, sidecarBContainerName))
+	framework.ExpectNoError(err, "waiting for webhook config %s to be honored", reinvocationConfigName)
+
+	By("create a pod and verify it carries exactly one of each sidecar")
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: reinvocationPodName},
+		Spec: v1.PodSpec{
+			Containers: []v1.Container{
+				{
+					Name:  "example",
+					Image: framework.GetPauseImageName(f.ClientSet),
+				},
+			},
+		},
+	}
+	createdPod, err := f.ClientSet.CoreV1().Pods(f.Namespace.Name).Create(pod)
+	framework.ExpectNoError(err, "creating pod %s in namespace %s", reinvocationPodName, f.Namespace.Name)
+
+	assertExactlyOneContainerNamed(createdPod, sidecarAContainerName)
+	assertExactlyOneContainerNamed(createdPod, sidecarBContainerName)
+}
+
+// testMutatingWebhookReinvocationDetectsBuggyDuplication pairs a buggy
+// webhook, which adds its sidecar unconditionally on every invocation, with
+// a well-behaved one that forces a reinvocation. This demonstrates that the
+// test harness can tell correct idempotent behavior (tested above) apart
+// from a webhook that breaks the reinvocation contract.
+func testMutatingWebhookReinvocationDetectsBuggyDuplication(f *framework.Framework, wc webhookClient, context *certContext) {
+	if !wc.SupportsReinvocationPolicy() {
+		framework.Skipf("reinvocationPolicy is not supported by the discovered admissionregistration API version")
+	}
+
+	By("Registering a buggy mutating webhook alongside one that forces reinvocation")
+	buggy := webhookDescriptor{
+		Name:               "buggy.reinvocation.k8s.io",
+		Path:               "/mutation-reinvocation-buggy-unconditional",
+		Operations:         []admissionregistrationv1.OperationType{admissionregistrationv1.Create},
+		APIGroups:          []string{""},
+		APIVersions:        []string{"v1"},
+		Resources:          []string{"pods"},
+		ReinvocationPolicy: reinvocationIfNeeded(),
+		CABundle:           context.signingCert,
+	}
+	forcesReinvocation := buggy
+	forcesReinvocation.Name = "b.reinvocation.k8s.io"
+	forcesReinvocation.Path = "/mutation-reinvocation-b"
+
+	err := wc.CreateMutating(reinvocationBuggyConfigName, []webhookDescriptor{buggy, forcesReinvocation})
+	framework.ExpectNoError(err, "registering mutating webhook config %s", reinvocationBuggyConfigName)
+	defer func() { _ = wc.Delete(reinvocationBuggyConfigName) }()
+	err = waitForWebhookConfigurationReady(reinvocationBuggyConfigName, podMutationProbe(f.ClientSet, f.Namespace.Name, buggySidecarContainerName))
+	framework.ExpectNoError(err, "waiting for webhook config %s to be honored", reinvocationBuggyConfigName)
+
+	By("create a pod and confirm the buggy webhook's duplicate mutation is detected")
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: reinvocationBuggyPodName},
+		Spec: v1.PodSpec{
+			Containers: []v1.Container{
+				{
+					Name:  "example",
+					Image: framework.GetPauseImageName(f.ClientSet),
+				},
+			},
+		},
+	}
+	createdPod, err := f.ClientSet.CoreV1().Pods(f.Namespace.Name).Create(pod)
+	framework.ExpectNoError(err, "creating pod %s in namespace %s", reinvocationBuggyPodName, f.Namespace.Name)
+
+	count := countContainersNamed(createdPod, buggySidecarContainerName)
+	if count <= 1 {
+		framework.Failf("expected the test harness to detect a duplicate %s container from the buggy webhook's reinvocation, got %d", buggySidecarContainerName, count)
+	}
+}
+
+func countContainersNamed(pod *v1.Pod, name string) int {
+	count := 0
+	for _, c := range pod.Spec.Containers {
+		if c.Name == name {
+			count++
+		}
+	}
+	return count
+}
+
+func assertExactlyOneContainerNamed(pod *v1.Pod, name string) {
+	count := countContainersNamed(pod, name)
+	if count != 1 {
+		framework.Failf("expected pod %s to have exactly one container named %s, got %d", pod.Name, name, count)
+	}
+}
@@ -0,0 +1,377 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Command webhook is the sample admission webhook server driven by
+// test/e2e/apimachinery/webhook.go. Each route below backs a scenario the
+// e2e suite registers dynamically; route names and behavior must stay in
+// sync with that file, which is the source of truth for what each webhook
+// is expected to do.
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/klog"
+)
+
+var (
+	certFile = flag.String("tls-cert-file", "", "file containing the x509 certificate for HTTPS")
+	keyFile  = flag.String("tls-private-key-file", "", "file containing the x509 private key for HTTPS")
+
+	scheme = runtime.NewScheme()
+	codecs = serializer.NewCodecFactory(scheme)
+)
+
+func init() {
+	_ = corev1.AddToScheme(scheme)
+	_ = admissionv1beta1.AddToScheme(scheme)
+}
+
+// admitFunc decides how a single AdmissionRequest should be handled.
+type admitFunc func(*admissionv1beta1.AdmissionRequest) *admissionv1beta1.AdmissionResponse
+
+func main() {
+	flag.Parse()
+
+	http.HandleFunc("/pods", admitFuncHandler(admitPods))
+	http.HandleFunc("/configmaps", admitFuncHandler(admitConfigMaps))
+	http.HandleFunc("/crd", admitFuncHandler(admitCRD))
+	http.HandleFunc("/always-deny", admitFuncHandler(alwaysDeny))
+	http.HandleFunc("/mutating-pods", admitFuncHandler(mutatePods))
+	http.HandleFunc("/mutating-configmaps", admitFuncHandler(mutateConfigMaps))
+	http.HandleFunc("/mutation-chain-a", admitFuncHandler(setPodAnnotation(mutationAnnotationKey, "1")))
+	http.HandleFunc("/mutation-chain-b", admitFuncHandler(setPodAnnotation(mutationAnnotationKey, "2")))
+	http.HandleFunc("/validate-mutation-chain", admitFuncHandler(requirePodAnnotation(mutationAnnotationKey, "2")))
+	http.HandleFunc("/mutation-reinvocation-a", admitFuncHandler(addSidecarIfAbsent("sidecar-a")))
+	http.HandleFunc("/mutation-reinvocation-b", admitFuncHandler(addSidecarIfAbsent("sidecar-b")))
+	http.HandleFunc("/mutation-reinvocation-buggy-unconditional", admitFuncHandler(addSidecarUnconditionally("buggy-sidecar")))
+
+	cert, err := tls.LoadX509KeyPair(*certFile, *keyFile)
+	if err != nil {
+		klog.Fatalf("loading tls cert/key: %v", err)
+	}
+	server := &http.Server{
+		Addr:      ":443",
+		TLSConfig: &tls.Config{Certificates: []tls.Certificate{cert}},
+	}
+	klog.Fatal(server.ListenAndServeTLS("", ""))
+}
+
+// admitFuncHandler decodes the incoming AdmissionReview, invokes admit, and
+// writes back an AdmissionReview carrying its response.
+func admitFuncHandler(admit admitFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		review := admissionv1beta1.AdmissionReview{}
+		if _, _, err := codecs.UniversalDeserializer().Decode(body, nil, &review); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		response := admit(review.Request)
+		response.UID = review.Request.UID
+		review.Response = response
+
+		out, err := json.Marshal(review)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if _, err := w.Write(out); err != nil {
+			klog.Errorf("writing response: %v", err)
+		}
+	}
+}
+
+func allowed() *admissionv1beta1.AdmissionResponse {
+	return &admissionv1beta1.AdmissionResponse{Allowed: true}
+}
+
+func denied(reason string) *admissionv1beta1.AdmissionResponse {
+	return &admissionv1beta1.AdmissionResponse{
+		Allowed: false,
+		Result:  &metav1.Status{Message: reason},
+	}
+}
+
+func toAdmissionResponse(err error) *admissionv1beta1.AdmissionResponse {
+	return denied(err.Error())
+}
+
+func patched(patch []byte) *admissionv1beta1.AdmissionResponse {
+	patchType := admissionv1beta1.PatchTypeJSONPatch
+	return &admissionv1beta1.AdmissionResponse{
+		Allowed:   true,
+		Patch:     patch,
+		PatchType: &patchType,
+	}
+}
+
+func decodePod(req *admissionv1beta1.AdmissionRequest) (*corev1.Pod, error) {
+	pod := &corev1.Pod{}
+	if err := json.Unmarshal(req.Object.Raw, pod); err != nil {
+		return nil, fmt.Errorf("decoding pod: %v", err)
+	}
+	return pod, nil
+}
+
+func decodeConfigMap(req *admissionv1beta1.AdmissionRequest) (*corev1.ConfigMap, error) {
+	cm := &corev1.ConfigMap{}
+	if err := json.Unmarshal(req.Object.Raw, cm); err != nil {
+		return nil, fmt.Errorf("decoding configmap: %v", err)
+	}
+	return cm, nil
+}
+
+// admitPods backs /pods: denies a pod carrying the disallowed container name
+// or label used by the e2e suite's nonCompliantPod fixture.
+func admitPods(req *admissionv1beta1.AdmissionRequest) *admissionv1beta1.AdmissionResponse {
+	pod, err := decodePod(req)
+	if err != nil {
+		return toAdmissionResponse(err)
+	}
+
+	var reasons []string
+	for _, c := range pod.Spec.Containers {
+		if c.Name == "webhook-disallow" {
+			reasons = append(reasons, "the pod contains unwanted container name")
+			break
+		}
+	}
+	if pod.Labels["webhook-e2e-test"] == "webhook-disallow" {
+		reasons = append(reasons, "the pod contains unwanted label")
+	}
+	if len(reasons) > 0 {
+		return denied(fmt.Sprint(reasons))
+	}
+	return allowed()
+}
+
+// admitConfigMaps backs /configmaps: denies a configmap carrying the
+// disallowed data key/value used by the e2e suite's nonCompliantConfigMap
+// fixture.
+func admitConfigMaps(req *admissionv1beta1.AdmissionRequest) *admissionv1beta1.AdmissionResponse {
+	cm, err := decodeConfigMap(req)
+	if err != nil {
+		return toAdmissionResponse(err)
+	}
+	if cm.Data["webhook-e2e-test"] == "webhook-disallow" {
+		return denied("the configmap contains unwanted key and value")
+	}
+	return allowed()
+}
+
+// admitCRD backs /crd: denies a custom resource carrying the same
+// disallowed data key/value as admitConfigMaps, in its "data" field.
+func admitCRD(req *admissionv1beta1.AdmissionRequest) *admissionv1beta1.AdmissionResponse {
+	raw := map[string]interface{}{}
+	if err := json.Unmarshal(req.Object.Raw, &raw); err != nil {
+		return toAdmissionResponse(fmt.Errorf("decoding custom resource: %v", err))
+	}
+	if data, ok := raw["data"].(map[string]interface{}); ok {
+		if data["webhook-e2e-test"] == "webhook-disallow" {
+			return denied("the custom resource contains unwanted data")
+		}
+	}
+	return allowed()
+}
+
+// alwaysDeny backs /always-deny, used by every scenario that only cares
+// whether a webhook intercepted a request at all (objectSelector, scope,
+// matchPolicy, namespaceSelector).
+func alwaysDeny(req *admissionv1beta1.AdmissionRequest) *admissionv1beta1.AdmissionResponse {
+	return denied("this webhook always denies")
+}
+
+type patchOp struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+func marshalPatch(ops []patchOp) ([]byte, error) {
+	return json.Marshal(ops)
+}
+
+const (
+	addedSidecarContainerName = "webhook-added-sidecar"
+	addedLabelKey             = "webhook-added-label"
+	addedLabelValue           = "yes"
+	addedConfigMapDataKey     = "mutation-stage"
+	addedConfigMapDataValue   = "first"
+	mutationAnnotationKey     = "foo"
+)
+
+// mutatePods backs /mutating-pods: injects a sidecar container and a label,
+// matching the e2e suite's testMutatingWebhook expectations.
+func mutatePods(req *admissionv1beta1.AdmissionRequest) *admissionv1beta1.AdmissionResponse {
+	pod, err := decodePod(req)
+	if err != nil {
+		return toAdmissionResponse(err)
+	}
+
+	ops := []patchOp{
+		{
+			Op:   "add",
+			Path: "/spec/containers/-",
+			Value: corev1.Container{
+				Name:  addedSidecarContainerName,
+				Image: "busybox",
+			},
+		},
+	}
+	if len(pod.Labels) == 0 {
+		ops = append(ops, patchOp{Op: "add", Path: "/metadata/labels", Value: map[string]string{addedLabelKey: addedLabelValue}})
+	} else {
+		ops = append(ops, patchOp{Op: "add", Path: "/metadata/labels/" + addedLabelKey, Value: addedLabelValue})
+	}
+
+	patch, err := marshalPatch(ops)
+	if err != nil {
+		return toAdmissionResponse(err)
+	}
+	return patched(patch)
+}
+
+// mutateConfigMaps backs /mutating-configmaps: injects a data key, matching
+// the e2e suite's testMutatingWebhook expectations.
+func mutateConfigMaps(req *admissionv1beta1.AdmissionRequest) *admissionv1beta1.AdmissionResponse {
+	cm, err := decodeConfigMap(req)
+	if err != nil {
+		return toAdmissionResponse(err)
+	}
+
+	var ops []patchOp
+	if len(cm.Data) == 0 {
+		ops = append(ops, patchOp{Op: "add", Path: "/data", Value: map[string]string{addedConfigMapDataKey: addedConfigMapDataValue}})
+	} else {
+		ops = append(ops, patchOp{Op: "add", Path: "/data/" + addedConfigMapDataKey, Value: addedConfigMapDataValue})
+	}
+
+	patch, err := marshalPatch(ops)
+	if err != nil {
+		return toAdmissionResponse(err)
+	}
+	return patched(patch)
+}
+
+// setPodAnnotation returns a mutating admitFunc that unconditionally sets
+// annotation key=value on the pod, used by the mutation chain's webhook A
+// (foo=1) and webhook B (foo=2, proving order and that B's write wins).
+func setPodAnnotation(key, value string) admitFunc {
+	return func(req *admissionv1beta1.AdmissionRequest) *admissionv1beta1.AdmissionResponse {
+		pod, err := decodePod(req)
+		if err != nil {
+			return toAdmissionResponse(err)
+		}
+
+		var ops []patchOp
+		if len(pod.Annotations) == 0 {
+			ops = append(ops, patchOp{Op: "add", Path: "/metadata/annotations", Value: map[string]string{key: value}})
+		} else {
+			ops = append(ops, patchOp{Op: "add", Path: "/metadata/annotations/" + key, Value: value})
+		}
+
+		patch, err := marshalPatch(ops)
+		if err != nil {
+			return toAdmissionResponse(err)
+		}
+		return patched(patch)
+	}
+}
+
+// requirePodAnnotation returns a validating admitFunc that denies the
+// request unless annotation key==value, used by the mutation chain's
+// trailing validating webhook to prove it observes webhook B's mutation.
+func requirePodAnnotation(key, value string) admitFunc {
+	return func(req *admissionv1beta1.AdmissionRequest) *admissionv1beta1.AdmissionResponse {
+		pod, err := decodePod(req)
+		if err != nil {
+			return toAdmissionResponse(err)
+		}
+		if pod.Annotations[key] != value {
+			return denied(fmt.Sprintf("expected annotation %s=%s, got %q", key, value, pod.Annotations[key]))
+		}
+		return allowed()
+	}
+}
+
+func hasContainerNamed(pod *corev1.Pod, name string) bool {
+	for _, c := range pod.Spec.Containers {
+		if c.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// addSidecarIfAbsent returns a mutating admitFunc that adds a sidecar
+// container named name only if the pod doesn't already carry one, so a
+// reinvocationPolicy: IfNeeded webhook can be reinvoked without duplicating
+// its own earlier mutation.
+func addSidecarIfAbsent(name string) admitFunc {
+	return func(req *admissionv1beta1.AdmissionRequest) *admissionv1beta1.AdmissionResponse {
+		pod, err := decodePod(req)
+		if err != nil {
+			return toAdmissionResponse(err)
+		}
+		if hasContainerNamed(pod, name) {
+			return allowed()
+		}
+
+		patch, err := marshalPatch([]patchOp{
+			{Op: "add", Path: "/spec/containers/-", Value: corev1.Container{Name: name, Image: "busybox"}},
+		})
+		if err != nil {
+			return toAdmissionResponse(err)
+		}
+		return patched(patch)
+	}
+}
+
+// addSidecarUnconditionally returns a mutating admitFunc that adds a
+// sidecar container named name on every invocation, regardless of whether
+// one is already present. It exists to model a webhook that breaks the
+// reinvocation idempotency contract, so the e2e suite's
+// testMutatingWebhookReinvocationDetectsBuggyDuplication has a real
+// duplicate to detect.
+func addSidecarUnconditionally(name string) admitFunc {
+	return func(req *admissionv1beta1.AdmissionRequest) *admissionv1beta1.AdmissionResponse {
+		patch, err := marshalPatch([]patchOp{
+			{Op: "add", Path: "/spec/containers/-", Value: corev1.Container{Name: name, Image: "busybox"}},
+		})
+		if err != nil {
+			return toAdmissionResponse(err)
+		}
+		return patched(patch)
+	}
+}